@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const defaultLogMaxBytes = 50 * 1024 * 1024
+
+// jobLogger persists every non-progress stderr/stdout line to disk when
+// --logs/FFPB_LOGS is set, rotating to a fresh file once the current one
+// passes maxBytes and keeping "ffpb-latest.log" pointed at whichever file
+// is current so CI pipelines can grep for errors after the bar has cleared
+// the screen.
+type jobLogger struct {
+	mu       sync.Mutex
+	maxBytes int64
+	cur      *os.File
+	written  int64
+}
+
+func newJobLogger(maxBytes int64) (*jobLogger, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultLogMaxBytes
+	}
+	l := &jobLogger{maxBytes: maxBytes}
+	if err := l.rotate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *jobLogger) rotate() error {
+	name := fmt.Sprintf("ffpb-%d-%d.log", os.Getpid(), time.Now().UnixNano())
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open log file: %s", err)
+	}
+	if l.cur != nil {
+		l.cur.Close()
+	}
+	l.cur = f
+	l.written = 0
+
+	const symlink = "ffpb-latest.log"
+	os.Remove(symlink)
+	os.Symlink(name, symlink)
+	return nil
+}
+
+func (l *jobLogger) writeLine(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, err := io.WriteString(l.cur, line)
+	if err != nil {
+		return
+	}
+	l.written += int64(n)
+	if l.written >= l.maxBytes {
+		l.rotate()
+	}
+}
+
+func (l *jobLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cur != nil {
+		l.cur.Close()
+	}
+}
+
+// traceCommand prints the exact argv and environment ffpb is about to exec,
+// gated on --trace/FFPB_TRACE, so users can see precisely what was launched
+// on their behalf.
+func traceCommand(trace bool, cmd *exec.Cmd) {
+	if !trace {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "+ exec: %v\n", cmd.Args)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, e := range env {
+		fmt.Fprintf(os.Stderr, "+ env: %s\n", e)
+	}
+}
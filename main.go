@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/cheggaaa/pb"
 	"github.com/kr/pty"
@@ -13,15 +14,186 @@ import (
 	"os/signal"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 )
 
 var (
 	ProgressTimeRegex = regexp.MustCompile(`\s+time=\s*((\d{2}):(\d{2}):(\d{2}))\.\d+`)
 	DurationRegex     = regexp.MustCompile(`\s+Duration:\s*((\d{2}):(\d{2}):(\d{2}))\.\d+`)
-	bar               *pb.ProgressBar
 )
 
+// options holds the ffpb-specific flags parsed out of os.Args before the
+// remainder is handed to exec.Command as the wrapped ffmpeg invocation.
+type options struct {
+	ProgressFormat string
+	ProgressFile   string
+	ProgressAddr   string
+
+	JobsFile string
+	Cmds     []string
+	Parallel int
+
+	Logs        bool
+	Silent      bool
+	Trace       bool
+	LogMaxBytes int64
+
+	RecordFile string
+
+	NativeProgress   bool
+	NoNativeProgress bool
+}
+
+const (
+	streamStdout byte = 0
+	streamStderr byte = 1
+)
+
+// jobContext carries everything that used to live in package-level globals
+// (the bar, the pty/pipe handles, cancellation) for a single wrapped ffmpeg
+// invocation. A plain `ffpb ffmpeg ...` run and each worker in `--jobs`/
+// `--cmd` multi-job mode are both just one jobContext apiece.
+type jobContext struct {
+	label           string
+	mu              sync.Mutex // guards bar and duration: stdout/stderr readers and native-progress parsing touch both concurrently
+	bar             *pb.ProgressBar
+	duration        int
+	cancel          context.CancelFunc
+	closeAfterStart []io.Closer
+	closeAfterWait  []io.Closer
+	logger          *jobLogger
+	silent          bool
+	// keepBarPrefix, when set, stops renderProgress from overwriting the
+	// bar's Prefix with each scraped status line. Multi-job mode sets this
+	// so a pooled bar keeps showing its "job-N" label instead of the raw
+	// ffmpeg status text; label alone can't gate this, since replay also
+	// uses non-empty labels ("stdout"/"stderr") for its per-stream
+	// contexts, not to mark a job worth labeling.
+	keepBarPrefix bool
+	// onProgress, if set, is notified from renderProgress's stderr-scraping
+	// path with this job's known duration and current elapsed time (both
+	// in seconds) every time either changes. Multi-job mode uses it to
+	// drive a duration-weighted "overall" bar; single-job mode, and the
+	// native -progress pipe: path (which multi-job mode never enables),
+	// leave it nil.
+	onProgress func(duration, elapsed int)
+}
+
+func newJobContext(label string, cancel context.CancelFunc) *jobContext {
+	return &jobContext{label: label, cancel: cancel}
+}
+
+// envBool reports whether the named environment variable is set to a
+// recognized truthy value, mirroring tools like redo's REDO_SILENT/
+// REDO_TRACE env vars.
+func envBool(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseArgs consumes ffpb's own leading flags and returns the unparsed
+// remainder as the child command and its arguments. It stops at the first
+// token it doesn't recognize, so `ffpb --progress-format=json ffmpeg -i ...`
+// works without ffpb needing to know anything about ffmpeg's own flags.
+func parseArgs(args []string) (*options, []string) {
+	opts := &options{
+		ProgressFormat: "plain",
+		Logs:           envBool("FFPB_LOGS"),
+		Silent:         envBool("FFPB_SILENT"),
+		Trace:          envBool("FFPB_TRACE"),
+	}
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+		switch {
+		case arg == "--progress-format" && i+1 < len(args):
+			opts.ProgressFormat = args[i+1]
+			i += 2
+		case strings.HasPrefix(arg, "--progress-format="):
+			opts.ProgressFormat = strings.TrimPrefix(arg, "--progress-format=")
+			i++
+		case arg == "--progress-file" && i+1 < len(args):
+			opts.ProgressFile = args[i+1]
+			i += 2
+		case strings.HasPrefix(arg, "--progress-file="):
+			opts.ProgressFile = strings.TrimPrefix(arg, "--progress-file=")
+			i++
+		case arg == "--progress-addr" && i+1 < len(args):
+			opts.ProgressAddr = args[i+1]
+			i += 2
+		case strings.HasPrefix(arg, "--progress-addr="):
+			opts.ProgressAddr = strings.TrimPrefix(arg, "--progress-addr=")
+			i++
+		case arg == "--jobs" && i+1 < len(args):
+			opts.JobsFile = args[i+1]
+			i += 2
+		case strings.HasPrefix(arg, "--jobs="):
+			opts.JobsFile = strings.TrimPrefix(arg, "--jobs=")
+			i++
+		case arg == "--cmd" && i+1 < len(args):
+			opts.Cmds = append(opts.Cmds, args[i+1])
+			i += 2
+		case strings.HasPrefix(arg, "--cmd="):
+			opts.Cmds = append(opts.Cmds, strings.TrimPrefix(arg, "--cmd="))
+			i++
+		case arg == "--parallel" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err == nil {
+				opts.Parallel = n
+			}
+			i += 2
+		case strings.HasPrefix(arg, "--parallel="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--parallel="))
+			if err == nil {
+				opts.Parallel = n
+			}
+			i++
+		case arg == "--logs":
+			opts.Logs = true
+			i++
+		case arg == "--silent":
+			opts.Silent = true
+			i++
+		case arg == "--trace":
+			opts.Trace = true
+			i++
+		case arg == "--log-max-bytes" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err == nil {
+				opts.LogMaxBytes = n
+			}
+			i += 2
+		case strings.HasPrefix(arg, "--log-max-bytes="):
+			n, err := strconv.ParseInt(strings.TrimPrefix(arg, "--log-max-bytes="), 10, 64)
+			if err == nil {
+				opts.LogMaxBytes = n
+			}
+			i++
+		case arg == "--record" && i+1 < len(args):
+			opts.RecordFile = args[i+1]
+			i += 2
+		case strings.HasPrefix(arg, "--record="):
+			opts.RecordFile = strings.TrimPrefix(arg, "--record=")
+			i++
+		case arg == "--native-progress":
+			opts.NativeProgress = true
+			i++
+		case arg == "--no-native-progress":
+			opts.NoNativeProgress = true
+			i++
+		default:
+			return opts, args[i:]
+		}
+	}
+	return opts, args[i:]
+}
+
 func splitLine(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
@@ -56,7 +228,11 @@ func findTime(re *regexp.Regexp, line string) (bool, int, string) {
 	return false, 0, ""
 }
 
-func renderProgress(duration int, line string, out *os.File) {
+func renderProgress(ctx *jobContext, line string, out *os.File) {
+	ctx.mu.Lock()
+	duration := ctx.duration
+	ctx.mu.Unlock()
+
 	if duration <= 0 {
 		fmt.Fprint(out, line)
 		return
@@ -66,59 +242,96 @@ func renderProgress(duration int, line string, out *os.File) {
 		fmt.Fprint(out, line)
 		return
 	}
-	if isatty.IsTerminal(out.Fd()) {
-		if bar == nil {
-			bar = initProgressBar(duration, out)
+	if len(progressSinks) > 0 {
+		emitProgress(parseProgressEvent(duration, current, line))
+	}
+
+	ctx.mu.Lock()
+	if ctx.bar != nil || isatty.IsTerminal(out.Fd()) {
+		if ctx.bar == nil {
+			ctx.bar = initProgressBar(ctx.label, duration, out)
+		} else if ctx.bar.Total == 0 {
+			ctx.bar.Total = int64(duration)
+		}
+		if !ctx.keepBarPrefix {
+			ctx.bar.Prefix(line[:len(line)-1])
 		}
-		bar.Prefix(line[:len(line)-1])
-		bar.Set(current)
+		ctx.bar.Set(current)
 	} else {
 		fmt.Fprint(out, line)
 	}
+	ctx.mu.Unlock()
+
+	if ctx.onProgress != nil {
+		ctx.onProgress(duration, current)
+	}
 }
 
-func initProgressBar(duration int, out *os.File) *pb.ProgressBar {
+func initProgressBar(label string, duration int, out *os.File) *pb.ProgressBar {
 	bar := pb.New(duration)
 	bar.Output = out
 	bar.SetUnits(pb.U_DURATION)
 	bar.ShowCounters = false
 	bar.ShowTimeLeft = false
+	if label != "" {
+		bar.Prefix(label)
+	}
 	bar.Start()
 	return bar
 }
 
-func readLine(in io.Reader, out *os.File) error {
+func readLine(ctx *jobContext, in io.Reader, out *os.File, recorder *sessionRecorder, stream byte) error {
 	scanner := bufio.NewScanner(in)
 	scanner.Split(splitLine)
-	duration := 0
 	for scanner.Scan() {
 		lineBytes := scanner.Bytes()
+		if recorder != nil {
+			recorder.record(stream, lineBytes)
+		}
 		line := string(lineBytes)
 		if lineBytes[len(lineBytes)-1] == '\r' {
-			renderProgress(duration, line, out)
+			renderProgress(ctx, line, out)
 		} else {
-			if bar != nil {
-				bar.Set64(bar.Total)
-				bar.Finish()
-			}
 			exists, t, _ := findTime(DurationRegex, line)
+
+			ctx.mu.Lock()
+			if ctx.bar != nil {
+				ctx.bar.Set64(ctx.bar.Total)
+				ctx.bar.Finish()
+			}
 			if exists {
-				duration = t
+				ctx.duration = t
+			}
+			ctx.mu.Unlock()
+
+			if ctx.logger != nil {
+				if ctx.label != "" {
+					ctx.logger.writeLine("[" + ctx.label + "] " + line)
+				} else {
+					ctx.logger.writeLine(line)
+				}
+			}
+			if !ctx.silent {
+				fmt.Fprint(out, line)
 			}
-			fmt.Fprint(out, line)
 		}
 	}
 	return scanner.Err()
 }
 
-func catchTerminate(cmd *exec.Cmd) {
+func catchTerminate(ctx *jobContext, cmd *exec.Cmd) {
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGKILL)
 	defer signal.Stop(signalCh)
 	select {
 	case ch := <-signalCh:
-		if bar != nil {
-			bar.Finish()
+		ctx.mu.Lock()
+		if ctx.bar != nil {
+			ctx.bar.Finish()
+		}
+		ctx.mu.Unlock()
+		if ctx.cancel != nil {
+			ctx.cancel()
 		}
 		cmd.Process.Signal(ch)
 		return
@@ -127,67 +340,200 @@ func catchTerminate(cmd *exec.Cmd) {
 
 func usage() {
 	fmt.Fprintln(os.Stderr, `ffpb - Non-invasive progress bar for FFmpeg
-usage: ffpb [command]
+usage: ffpb [--progress-format=json|plain] [--progress-file=path] [--progress-addr=host:port] [command]
+       ffpb [--jobs=file] [--cmd="..."]... [--parallel=N]
+       ffpb [--logs] [--silent] [--trace] [--log-max-bytes=N] [command]
+       ffpb [--record=session.ffpb] [command]
+       ffpb replay session.ffpb
+       ffpb [--native-progress|--no-native-progress] [command]
 example:
 	ffpb ffmpeg [options]
-	ffmpeg [options] |& ffpb`)
+	ffmpeg [options] |& ffpb
+	ffpb --progress-format=json --progress-addr=:9191 ffmpeg [options]
+	ffpb --jobs=jobs.txt --parallel=4
+	ffpb --logs --silent ffmpeg [options]
+	ffpb --record=session.ffpb ffmpeg [options]
+	ffpb replay session.ffpb`)
 	os.Exit(1)
 }
 
 func main() {
 
+	if len(os.Args) >= 2 && os.Args[1] == "replay" {
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "usage: ffpb replay session.ffpb")
+			os.Exit(1)
+		}
+		if err := replaySession(os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "replay error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if len(os.Args) == 1 {
 		if isatty.IsTerminal(os.Stdin.Fd()) {
 			usage()
 		}
-		readLine(os.Stdin, os.Stdout)
+		readLine(newJobContext("", nil), os.Stdin, os.Stdout, nil, streamStdout)
 		return
 	}
 
-	cmd := exec.Command(os.Args[1], os.Args[2:]...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setctty: true,
-		Setsid:  true,
+	opts, childArgs := parseArgs(os.Args[1:])
+	if len(childArgs) == 0 && opts.JobsFile == "" && len(opts.Cmds) == 0 {
+		usage()
+	}
+
+	sinks, err := setupProgressSinks(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "progress sink error: %s\n", err)
+		os.Exit(1)
+	}
+	progressSinks = sinks
+	defer func() {
+		for _, s := range progressSinks {
+			s.close()
+		}
+	}()
+
+	if opts.JobsFile != "" || len(opts.Cmds) > 0 {
+		commands, err := loadJobs(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "jobs error: %s\n", err)
+			os.Exit(1)
+		}
+		if len(commands) == 0 {
+			usage()
+		}
+		os.Exit(runJobs(opts, commands))
+	}
+
+	var logger *jobLogger
+	if opts.Logs {
+		logger, err = newJobLogger(opts.LogMaxBytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log file error: %s\n", err)
+			os.Exit(1)
+		}
+		defer logger.close()
+	}
+
+	var recorder *sessionRecorder
+	if opts.RecordFile != "" {
+		recorder, err = newSessionRecorder(opts.RecordFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "record file error: %s\n", err)
+			os.Exit(1)
+		}
+		defer recorder.close()
+	}
+
+	ctx := newJobContext("", nil)
+	ctx.logger = logger
+	ctx.silent = opts.Silent
+
+	buildCmd := func(args []string) *exec.Cmd {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setctty: true,
+			Setsid:  true,
+		}
+		return cmd
+	}
+
+	var nativeRead, nativeWrite *os.File
+	// --record only ever hooks readLine (stdout/stderr), so native progress
+	// (which moves frames onto fd 3 via readNativeProgress, and injects
+	// -nostats to keep them off stderr) would leave the recording with a
+	// Duration: line but zero progress frames -- replay would then show no
+	// bar at all. Force stderr scraping whenever a recording is requested.
+	useNative := opts.RecordFile == "" && wantsNativeProgress(opts, childArgs)
+	cmdArgs := childArgs
+	if useNative {
+		injected, pr, pw, nerr := prepareNativeProgress(childArgs)
+		if nerr != nil {
+			fmt.Fprintf(os.Stderr, "native progress unavailable, falling back to stderr scraping: %s\n", nerr)
+			useNative = false
+		} else {
+			cmdArgs = injected
+			nativeRead, nativeWrite = pr, pw
+		}
+	}
+
+	cmd := buildCmd(cmdArgs)
+	if useNative {
+		cmd.ExtraFiles = []*os.File{nativeWrite}
 	}
+	traceCommand(opts.Trace, cmd)
 
-	stdinWriter, stdoutReader, stderrReader, closeAfterStart, closeAfterWait := redirect(cmd)
+	stdinWriter, stdoutReader, stderrReader := redirect(ctx, cmd)
 
-	err := cmd.Start()
+	err = cmd.Start()
+	if err != nil && useNative {
+		// Starting the child with the extra fd failed; retry once with the
+		// original argv and plain stderr scraping instead of failing the
+		// whole run. A child that starts and then rejects -progress pipe:3
+		// itself is not retried.
+		fmt.Fprintf(os.Stderr, "start with native progress fd failed, retrying without it: %s\n", err)
+		nativeWrite.Close()
+		nativeRead.Close()
+		for _, c := range ctx.closeAfterStart {
+			c.Close()
+		}
+		for _, c := range ctx.closeAfterWait {
+			c.Close()
+		}
+		useNative = false
+		ctx = newJobContext("", nil)
+		ctx.logger = logger
+		ctx.silent = opts.Silent
+		cmd = buildCmd(childArgs)
+		traceCommand(opts.Trace, cmd)
+		stdinWriter, stdoutReader, stderrReader = redirect(ctx, cmd)
+		err = cmd.Start()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "cmd start error %s", err)
 		os.Exit(1)
 	}
 
+	if useNative {
+		nativeWrite.Close()
+		// The stderr-scraping path always draws on os.Stderr regardless of
+		// where the child's stdout is redirected; native progress needs to
+		// match, or `ffpb ffmpeg ... out.mp4 >redirected` (stdout redirected,
+		// stderr a TTY) loses its bar and gets the synthesized time= line
+		// written into the redirect instead.
+		go readNativeProgress(ctx, nativeRead, os.Stderr)
+	}
+
 	go io.Copy(stdinWriter, os.Stdin)
-	go readLine(stdoutReader, os.Stdout)
-	go readLine(stderrReader, os.Stderr)
+	go readLine(ctx, stdoutReader, os.Stdout, recorder, streamStdout)
+	go readLine(ctx, stderrReader, os.Stderr, recorder, streamStderr)
 
-	for _, c := range closeAfterStart {
+	for _, c := range ctx.closeAfterStart {
 		c.Close()
 	}
 	defer func() {
-		for _, c := range closeAfterWait {
+		for _, c := range ctx.closeAfterWait {
 			c.Close()
 		}
 	}()
 
-	go catchTerminate(cmd)
+	go catchTerminate(ctx, cmd)
 
 	cmd.Wait()
 }
 
-func redirect(cmd *exec.Cmd) (io.Writer, io.ReadCloser, io.ReadCloser, []io.Closer, []io.Closer) {
-	var closeAfterStart []io.Closer
-	var closeAfterWait []io.Closer
-
+func redirect(ctx *jobContext, cmd *exec.Cmd) (io.Writer, io.ReadCloser, io.ReadCloser) {
 	ptyStdin, ttyStdin, err := pty.Open()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "pty open error %s", err)
 		os.Exit(1)
 	}
 	cmd.Stdin = ttyStdin
-	closeAfterStart = append(closeAfterStart, ttyStdin)
-	closeAfterWait = append(closeAfterWait, ptyStdin)
+	ctx.closeAfterStart = append(ctx.closeAfterStart, ttyStdin)
+	ctx.closeAfterWait = append(ctx.closeAfterWait, ptyStdin)
 
 	var stdoutReader io.ReadCloser
 	if isatty.IsTerminal(os.Stdout.Fd()) {
@@ -197,8 +543,8 @@ func redirect(cmd *exec.Cmd) (io.Writer, io.ReadCloser, io.ReadCloser, []io.Clos
 			os.Exit(1)
 		}
 		cmd.Stdout = ttyStdout
-		closeAfterStart = append(closeAfterStart, ttyStdout)
-		closeAfterWait = append(closeAfterWait, ptyStdout)
+		ctx.closeAfterStart = append(ctx.closeAfterStart, ttyStdout)
+		ctx.closeAfterWait = append(ctx.closeAfterWait, ptyStdout)
 		stdoutReader = ptyStdout
 	} else {
 		stdoutReader, err = cmd.StdoutPipe()
@@ -216,8 +562,8 @@ func redirect(cmd *exec.Cmd) (io.Writer, io.ReadCloser, io.ReadCloser, []io.Clos
 			os.Exit(1)
 		}
 		stderrReader = ptyStderr
-		closeAfterStart = append(closeAfterStart, ttyStderr)
-		closeAfterWait = append(closeAfterWait, ptyStderr)
+		ctx.closeAfterStart = append(ctx.closeAfterStart, ttyStderr)
+		ctx.closeAfterWait = append(ctx.closeAfterWait, ptyStderr)
 		cmd.Stderr = ttyStderr
 	} else {
 		stderrReader, err = cmd.StderrPipe()
@@ -227,5 +573,5 @@ func redirect(cmd *exec.Cmd) (io.Writer, io.ReadCloser, io.ReadCloser, []io.Clos
 		}
 	}
 
-	return ptyStdin, stdoutReader, stderrReader, closeAfterStart, closeAfterWait
+	return ptyStdin, stdoutReader, stderrReader
 }
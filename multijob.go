@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cheggaaa/pb"
+)
+
+// loadJobs assembles the list of ffmpeg command lines to run from --jobs
+// (one command per line, blanks and #-comments skipped) and/or repeated
+// --cmd flags, in the order given.
+func loadJobs(opts *options) ([]string, error) {
+	var commands []string
+
+	if opts.JobsFile != "" {
+		f, err := os.Open(opts.JobsFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			commands = append(commands, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	commands = append(commands, opts.Cmds...)
+	return commands, nil
+}
+
+// splitCommand tokenizes a --jobs/--cmd command line the way a shell would
+// for argv purposes: whitespace separates arguments, but a single-quoted,
+// double-quoted, or backslash-escaped run of whitespace stays inside one
+// argument. strings.Fields would otherwise shatter filtergraphs
+// (-vf "drawtext=text='Hello World'") and paths with spaces (-i "my video.mp4")
+// into multiple argv entries, quote characters and all. This only does word
+// splitting and quote/escape removal -- no $VAR expansion, globs, pipes, or
+// subshells.
+func splitCommand(command string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\'':
+			inField = true
+			j := i + 1
+			for j < len(runes) && runes[j] != '\'' {
+				cur.WriteRune(runes[j])
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated '")
+			}
+			i = j
+		case c == '"':
+			inField = true
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' && j+1 < len(runes) && strings.ContainsRune(`"\$`, runes[j+1]) {
+					cur.WriteRune(runes[j+1])
+					j += 2
+					continue
+				}
+				cur.WriteRune(runes[j])
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated \"")
+			}
+			i = j
+		case c == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			inField = true
+			cur.WriteRune(runes[i+1])
+			i++
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			inField = true
+			cur.WriteRune(c)
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+type jobResult struct {
+	label    string
+	command  string
+	exitCode int
+	err      error
+}
+
+// overallProgress drives the fleet's aggregate "overall" bar from each
+// job's own duration and elapsed time, so a 2-hour job and a 2-second job
+// don't advance it equally the way counting finished jobs would.
+type overallProgress struct {
+	mu            sync.Mutex
+	bar           *pb.ProgressBar
+	duration      map[string]int64
+	elapsed       map[string]int64
+	totalDuration int64
+	totalElapsed  int64
+}
+
+func newOverallProgress(bar *pb.ProgressBar) *overallProgress {
+	return &overallProgress{bar: bar, duration: map[string]int64{}, elapsed: map[string]int64{}}
+}
+
+// report records label's latest known duration and elapsed time (seconds)
+// and resizes/advances the overall bar to the running sums across all
+// jobs, adjusting by the delta instead of re-summing every job on every
+// call.
+func (o *overallProgress) report(label string, duration, elapsed int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.totalDuration += int64(duration) - o.duration[label]
+	o.totalElapsed += int64(elapsed) - o.elapsed[label]
+	o.duration[label] = int64(duration)
+	o.elapsed[label] = int64(elapsed)
+
+	o.bar.Total = o.totalDuration
+	o.bar.Set64(o.totalElapsed)
+}
+
+// runJobs spawns up to opts.Parallel concurrent ffmpeg workers, one per
+// command line, each rendering its own labeled bar in a shared pb.Pool
+// alongside an aggregate "overall" bar sized and advanced by the sum of
+// each job's own duration and elapsed time, not by job count. SIGINT/
+// SIGTERM cancel the whole fleet. It returns the first non-zero child exit
+// code seen (0 if every job succeeded), after printing a summary table.
+func runJobs(opts *options, commands []string) int {
+	if opts.RecordFile != "" {
+		fmt.Fprintln(os.Stderr, "record error: --record isn't supported with --jobs/--cmd, since replay keeps only one bar per stream for the whole session and a later job would corrupt an earlier job's replayed progress")
+		return 1
+	}
+
+	parallel := opts.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	// Build every job's bar up front, in command order, so they can all be
+	// added to the pool before the aggregate "overall" bar is -- pool.Add
+	// appends to the render order, and overall is meant to sit at the
+	// bottom, below every job it's summarizing.
+	bars := make([]*pb.ProgressBar, len(commands))
+	for i := range commands {
+		bar := pb.New(0)
+		bar.Prefix(fmt.Sprintf("job-%d", i+1))
+		bar.SetUnits(pb.U_DURATION)
+		bar.ShowCounters = false
+		bar.ShowTimeLeft = false
+		bars[i] = bar
+	}
+
+	overallBar := pb.New(0)
+	overallBar.Prefix("overall")
+	overallBar.SetUnits(pb.U_DURATION)
+	overallBar.ShowTimeLeft = false
+	overall := newOverallProgress(overallBar)
+
+	pool, err := pb.StartPool(append(append([]*pb.ProgressBar{}, bars...), overallBar)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pool start error %s\n", err)
+		return 1
+	}
+
+	fleetCtx, cancelFleet := context.WithCancel(context.Background())
+	go catchFleetTerminate(cancelFleet)
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		devNull = os.Stderr
+	} else {
+		defer devNull.Close()
+	}
+
+	var logger *jobLogger
+	if opts.Logs {
+		logger, err = newJobLogger(opts.LogMaxBytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log file error: %s\n", err)
+			return 1
+		}
+		defer logger.close()
+	}
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var results []jobResult
+	exitCode := 0
+
+	for i, command := range commands {
+		i, command := i, command
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			label := fmt.Sprintf("job-%d", i+1)
+			code, jobErr := runJob(fleetCtx, bars[i], label, command, devNull, opts, logger, overall)
+
+			mu.Lock()
+			results = append(results, jobResult{label: label, command: command, exitCode: code, err: jobErr})
+			if code != 0 && exitCode == 0 {
+				exitCode = code
+			}
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	overallBar.Finish()
+	pool.Stop()
+
+	printJobSummary(results)
+	return exitCode
+}
+
+// catchFleetTerminate cancels every running job's context when ffpb itself
+// receives an interrupt, so a single Ctrl-C tears down the whole fleet
+// instead of leaving the other workers running.
+func catchFleetTerminate(cancel context.CancelFunc) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+	<-signalCh
+	cancel()
+}
+
+// runJob runs a single ffmpeg invocation as one worker in the fleet, driving
+// the bar the caller already added to the shared pool, reporting its
+// duration/elapsed progress into overall, and terminating the child if
+// fleetCtx is canceled.
+func runJob(fleetCtx context.Context, bar *pb.ProgressBar, label, command string, sink *os.File, opts *options, logger *jobLogger, overall *overallProgress) (int, error) {
+	// runJobs already added bar to the pool before this job ever started, so
+	// every return path -- including the early ones below, which never reach
+	// the bar.Finish() calls further down -- must finish it; otherwise a bad
+	// command line or a pipe/start failure leaves its line stuck rendering
+	// at 0/0 in the pool for the rest of the fleet's run. Finishing twice is
+	// harmless (readLine's own duration-reset path already relies on that).
+	defer bar.Finish()
+
+	fields, err := splitCommand(command)
+	if err != nil {
+		return 1, fmt.Errorf("%s: %s", label, err)
+	}
+	if len(fields) == 0 {
+		return 1, fmt.Errorf("%s: empty command", label)
+	}
+
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	ctx := newJobContext(label, nil)
+	ctx.logger = logger
+	ctx.silent = opts.Silent
+	ctx.keepBarPrefix = true
+	ctx.onProgress = func(duration, elapsed int) { overall.report(label, duration, elapsed) }
+	ctx.bar = bar
+
+	stdoutReader, err := cmd.StdoutPipe()
+	if err != nil {
+		return 1, err
+	}
+	stderrReader, err := cmd.StderrPipe()
+	if err != nil {
+		return 1, err
+	}
+
+	traceCommand(opts.Trace, cmd)
+
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+
+	stdoutDone := make(chan struct{})
+	stderrDone := make(chan struct{})
+	go func() { readLine(ctx, stdoutReader, sink, nil, streamStdout); close(stdoutDone) }()
+	go func() { readLine(ctx, stderrReader, sink, nil, streamStderr); close(stderrDone) }()
+
+	// cmd.Wait closes the stdout/stderr pipes as soon as it sees the child
+	// exit, and os/exec's own docs warn that's unsafe to do before all reads
+	// from those pipes have completed -- an in-flight readLine could have its
+	// last, unflushed lines (e.g. the final summary) truncated out from under
+	// it. The child's own exit already closes its end of the pipes and wakes
+	// the readers on its own, so waiting for stdoutDone/stderrDone here before
+	// calling Wait costs nothing and keeps Wait from racing the drain.
+	done := make(chan error, 1)
+	go func() {
+		<-stdoutDone
+		<-stderrDone
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case <-fleetCtx.Done():
+		// Signal the whole process group, not just cmd.Process: Setsid above
+		// makes this job's pid its own group leader, so any grandchild the
+		// wrapped command forked off inherits that group and dies with it
+		// too, instead of lingering with our stdout/stderr pipes held open
+		// and wedging the reader goroutines (and thus done) forever.
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		<-done
+		ctx.mu.Lock()
+		ctx.bar.Finish()
+		ctx.mu.Unlock()
+		// Don't report (total, total) here: the job was killed mid-encode,
+		// not completed, so overall should keep reflecting whatever elapsed
+		// time its last onProgress call recorded rather than jumping to 100%.
+		return 1, fleetCtx.Err()
+	case waitErr := <-done:
+		ctx.mu.Lock()
+		ctx.bar.Set64(ctx.bar.Total)
+		ctx.bar.Finish()
+		total := ctx.bar.Total
+		ctx.mu.Unlock()
+		if waitErr == nil {
+			overall.report(label, int(total), int(total))
+		}
+		// On failure, leave overall at whatever its last onProgress call
+		// recorded: the job stopped partway through, so reporting (total,
+		// total) here would overstate fleet-wide progress the same way the
+		// cancelled-job branch above must avoid.
+		if waitErr != nil {
+			if exitErr, ok := waitErr.(*exec.ExitError); ok {
+				return exitErr.ExitCode(), waitErr
+			}
+			return 1, waitErr
+		}
+		return 0, nil
+	}
+}
+
+func printJobSummary(results []jobResult) {
+	fmt.Fprintln(os.Stderr, "\nsummary:")
+	for _, r := range results {
+		status := "ok"
+		if r.exitCode != 0 {
+			status = fmt.Sprintf("failed (exit %d)", r.exitCode)
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %s -- %s\n", r.label, status, r.command)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// hasDashProgressFlag reports whether the wrapped argv already passes its
+// own `-progress` flag, in which case ffpb shouldn't inject a second one.
+func hasDashProgressFlag(args []string) bool {
+	return hasFlag(args, "-progress")
+}
+
+// hasNostatsFlag reports whether the wrapped argv already passes
+// `-nostats`.
+func hasNostatsFlag(args []string) bool {
+	return hasFlag(args, "-nostats")
+}
+
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args[1:] {
+		if a == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsNativeProgress decides whether to parse ffmpeg's documented
+// `-progress pipe:` key=value stream instead of screen-scraping stderr.
+// It's auto-enabled whenever the wrapped argv doesn't already ask for its
+// own -progress output, and can be forced either way with
+// --native-progress/--no-native-progress.
+func wantsNativeProgress(opts *options, childArgs []string) bool {
+	if opts.NoNativeProgress {
+		return false
+	}
+	if opts.NativeProgress {
+		return true
+	}
+	return !hasDashProgressFlag(childArgs)
+}
+
+// prepareNativeProgress opens the extra pipe ffmpeg will write its progress
+// frames to and returns argv with `-progress pipe:3` inserted right after
+// the binary name. It also injects `-nostats` (unless the caller already
+// passed one) so ffmpeg stops printing its own `time=` stats line to
+// stderr — without it, the stderr scanner's ProgressTimeRegex path would
+// keep driving the same bar concurrently with the native feed. The caller
+// is responsible for attaching pw as cmd.ExtraFiles[0] (which lands as fd
+// 3 in the child) and closing its own copy of pw once the child has
+// started.
+func prepareNativeProgress(childArgs []string) (args []string, pr, pw *os.File, err error) {
+	pr, pw, err = os.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	args = make([]string, 0, len(childArgs)+4)
+	args = append(args, childArgs[0], "-progress", "pipe:3")
+	if !hasNostatsFlag(childArgs) {
+		args = append(args, "-nostats")
+	}
+	args = append(args, childArgs[1:]...)
+	return args, pr, pw, nil
+}
+
+// readNativeProgress parses ffmpeg's `-progress pipe:` key=value frames
+// (out_time_ms, speed, fps, bitrate, progress=continue|end, ...) and drives
+// ctx's bar directly, bypassing the stderr time= regex entirely. Duration is
+// still only known once the stderr scanner has seen the input's
+// "Duration:" line, so the bar stays inactive until ctx.duration is set.
+func readNativeProgress(ctx *jobContext, in io.Reader, out *os.File) error {
+	scanner := bufio.NewScanner(in)
+	frame := map[string]string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		frame[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		if key != "progress" {
+			continue
+		}
+		applyNativeProgress(ctx, out, frame)
+		done := val == "end"
+		frame = map[string]string{}
+		if done {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+func applyNativeProgress(ctx *jobContext, out *os.File, frame map[string]string) {
+	outTimeMs, _ := strconv.ParseInt(frame["out_time_ms"], 10, 64)
+	timeSeconds := float64(outTimeMs) / 1e6
+
+	ctx.mu.Lock()
+	duration := ctx.duration
+	ctx.mu.Unlock()
+
+	ev := ProgressEvent{
+		TimeSeconds:     timeSeconds,
+		DurationSeconds: float64(duration),
+		Speed:           frame["speed"],
+		FPS:             frame["fps"],
+		Bitrate:         frame["bitrate"],
+	}
+	if duration > 0 {
+		ev.Fraction = timeSeconds / float64(duration)
+	}
+	if len(progressSinks) > 0 {
+		emitProgress(ev)
+	}
+
+	if duration <= 0 {
+		return
+	}
+
+	ctx.mu.Lock()
+	if ctx.bar != nil || isatty.IsTerminal(out.Fd()) {
+		if ctx.bar == nil {
+			ctx.bar = initProgressBar(ctx.label, ctx.duration, out)
+		} else if ctx.bar.Total == 0 {
+			ctx.bar.Total = int64(ctx.duration)
+		}
+		ctx.bar.Set(int(timeSeconds))
+
+		if frame["progress"] == "end" {
+			ctx.bar.Set64(ctx.bar.Total)
+			ctx.bar.Finish()
+		}
+	} else {
+		// Off a terminal there's no bar to draw, but a redirected log
+		// still needs *some* progress text — baseline ffpb passed
+		// ffmpeg's own stats line through verbatim here, and -nostats
+		// now suppresses that, so print an equivalent line ourselves.
+		fmt.Fprintf(out, "time=%s speed=%s fps=%s bitrate=%s\n",
+			time.Duration(timeSeconds*float64(time.Second)).Truncate(time.Second),
+			frame["speed"], frame["fps"], frame["bitrate"])
+	}
+	ctx.mu.Unlock()
+}
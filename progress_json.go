@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	speedRegex   = regexp.MustCompile(`speed=\s*([0-9.]+x)`)
+	fpsRegex     = regexp.MustCompile(`fps=\s*([0-9.]+)`)
+	bitrateRegex = regexp.MustCompile(`bitrate=\s*([0-9.a-zA-Z/]+)`)
+)
+
+// ProgressEvent is one JSON-encodable snapshot of ffmpeg's progress, parsed
+// from a status line alongside the terminal bar update.
+type ProgressEvent struct {
+	TimeSeconds     float64 `json:"time_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Fraction        float64 `json:"fraction"`
+	Speed           string  `json:"speed"`
+	FPS             string  `json:"fps"`
+	Bitrate         string  `json:"bitrate"`
+}
+
+func parseProgressEvent(duration, current int, line string) ProgressEvent {
+	ev := ProgressEvent{
+		TimeSeconds:     float64(current),
+		DurationSeconds: float64(duration),
+	}
+	if duration > 0 {
+		ev.Fraction = float64(current) / float64(duration)
+	}
+	if m := speedRegex.FindStringSubmatch(line); m != nil {
+		ev.Speed = m[1]
+	}
+	if m := fpsRegex.FindStringSubmatch(line); m != nil {
+		ev.FPS = m[1]
+	}
+	if m := bitrateRegex.FindStringSubmatch(line); m != nil {
+		ev.Bitrate = m[1]
+	}
+	return ev
+}
+
+// progressSink receives every parsed progress event in addition to the
+// terminal bar. send must not block the reader goroutine for long.
+type progressSink interface {
+	send(ProgressEvent)
+	close()
+}
+
+var progressSinks []progressSink
+
+func emitProgress(ev ProgressEvent) {
+	for _, s := range progressSinks {
+		s.send(ev)
+	}
+}
+
+// setupProgressSinks builds the sinks requested by --progress-file and
+// --progress-addr, falling back to stderr when --progress-format=json was
+// given without naming a destination.
+func setupProgressSinks(opts *options) ([]progressSink, error) {
+	var sinks []progressSink
+
+	if opts.ProgressFile != "" {
+		f, err := os.OpenFile(opts.ProgressFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("progress file: %s", err)
+		}
+		sinks = append(sinks, newWriterSink(f, opts.ProgressFormat))
+	}
+
+	if opts.ProgressAddr != "" {
+		ln, err := newListenerSink(opts.ProgressAddr)
+		if err != nil {
+			return nil, fmt.Errorf("progress addr: %s", err)
+		}
+		sinks = append(sinks, ln)
+	}
+
+	if len(sinks) == 0 && opts.ProgressFormat == "json" {
+		sinks = append(sinks, newWriterSink(os.Stderr, opts.ProgressFormat))
+	}
+
+	return sinks, nil
+}
+
+// writerSink writes each event to a plain io.Writer, either as JSON or as a
+// human-readable key=value line.
+type writerSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+func newWriterSink(w io.Writer, format string) *writerSink {
+	return &writerSink{w: w, format: format}
+}
+
+func (s *writerSink) send(ev ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.format == "plain" {
+		fmt.Fprintf(s.w, "time=%.2f duration=%.2f fraction=%.4f speed=%s fps=%s bitrate=%s\n",
+			ev.TimeSeconds, ev.DurationSeconds, ev.Fraction, ev.Speed, ev.FPS, ev.Bitrate)
+		return
+	}
+	json.NewEncoder(s.w).Encode(ev)
+}
+
+func (s *writerSink) close() {
+	if c, ok := s.w.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// listenerSink accepts TCP or Unix-socket clients on --progress-addr and
+// streams events to each of them as chunked text/event-stream, so external
+// dashboards can subscribe to a running job without screen-scraping the
+// terminal bar. A client more than 16 events behind misses the events sent
+// while its buffer is full.
+type listenerSink struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	clients map[chan ProgressEvent]struct{}
+	closed  bool
+}
+
+func newListenerSink(addr string) (*listenerSink, error) {
+	network := "tcp"
+	if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &listenerSink{ln: ln, clients: make(map[chan ProgressEvent]struct{})}
+	go s.acceptLoop()
+	return s, nil
+}
+
+func (s *listenerSink) acceptLoop() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *listenerSink) serve(conn net.Conn) {
+	defer conn.Close()
+	// We only ever serve one logical resource, so the request line (and any
+	// headers) can be discarded once read.
+	bufio.NewReader(conn).ReadString('\n')
+
+	fmt.Fprint(conn, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/event-stream\r\n"+
+		"Cache-Control: no-cache\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Connection: keep-alive\r\n\r\n")
+
+	ch := make(chan ProgressEvent, 16)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	for ev := range ch {
+		payload, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		frame := fmt.Sprintf("data: %s\n\n", payload)
+		if _, err := fmt.Fprintf(conn, "%x\r\n%s\r\n", len(frame), frame); err != nil {
+			return
+		}
+	}
+}
+
+func (s *listenerSink) send(ev ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	for ch := range s.clients {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// close shuts the listener down and closes every client channel exactly
+// once. It's guarded by the same mutex send() uses so a send() racing
+// with (or arriving after) close() sees s.closed instead of writing to an
+// already-closed channel, which would panic even inside a select.
+func (s *listenerSink) close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for ch := range s.clients {
+		close(ch)
+	}
+	s.mu.Unlock()
+	s.ln.Close()
+}
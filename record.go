@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recorded session is a sequence of length-prefixed frames so that binary
+// carriage-return frames from ffmpeg's progress lines survive intact:
+//
+//	offsetNanos int64   (8 bytes, big endian, ns since recording start)
+//	stream      byte    (streamStdout or streamStderr)
+//	length      uint32  (4 bytes, big endian)
+//	data        [length]byte
+const sessionFrameHeaderSize = 8 + 1 + 4
+
+// sessionRecorder writes every line ffpb reads from the wrapped ffmpeg to a
+// --record file, prefixed by a monotonic offset and stream id, so sessions
+// can be replayed later with `ffpb replay`.
+type sessionRecorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	start time.Time
+}
+
+func newSessionRecorder(path string) (*sessionRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionRecorder{f: f, start: time.Now()}, nil
+}
+
+func (r *sessionRecorder) record(stream byte, line []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var header [sessionFrameHeaderSize]byte
+	binary.BigEndian.PutUint64(header[0:8], uint64(time.Since(r.start).Nanoseconds()))
+	header[8] = stream
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(line)))
+
+	r.f.Write(header[:])
+	r.f.Write(line)
+}
+
+func (r *sessionRecorder) close() {
+	r.f.Close()
+}
+
+type sessionFrame struct {
+	offset time.Duration
+	stream byte
+	data   []byte
+}
+
+func readSessionFrames(path string) ([]sessionFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frames []sessionFrame
+	header := make([]byte, sessionFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		offsetNanos := int64(binary.BigEndian.Uint64(header[0:8]))
+		stream := header[8]
+		length := binary.BigEndian.Uint32(header[9:13])
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, err
+		}
+		frames = append(frames, sessionFrame{offset: time.Duration(offsetNanos), stream: stream, data: data})
+	}
+	return frames, nil
+}
+
+// replaySession feeds a recorded session back through renderProgress,
+// honoring the original inter-line timing, so progress-bar rendering can be
+// demoed or regression-tested against real captured ffmpeg chatter without
+// re-encoding anything.
+func replaySession(path string) error {
+	frames, err := readSessionFrames(path)
+	if err != nil {
+		return err
+	}
+
+	ctxByStream := map[byte]*jobContext{
+		streamStdout: newJobContext("stdout", nil),
+		streamStderr: newJobContext("stderr", nil),
+	}
+	outByStream := map[byte]*os.File{
+		streamStdout: os.Stdout,
+		streamStderr: os.Stderr,
+	}
+
+	start := time.Now()
+	for _, fr := range frames {
+		if wait := fr.offset - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+
+		ctx, ok := ctxByStream[fr.stream]
+		if !ok {
+			continue
+		}
+		out := outByStream[fr.stream]
+		line := string(fr.data)
+
+		if len(fr.data) > 0 && fr.data[len(fr.data)-1] == '\r' {
+			renderProgress(ctx, line, out)
+			continue
+		}
+		if ctx.bar != nil {
+			ctx.bar.Set64(ctx.bar.Total)
+			ctx.bar.Finish()
+		}
+		if exists, t, _ := findTime(DurationRegex, line); exists {
+			ctx.duration = t
+		}
+		fmt.Fprint(out, line)
+	}
+	return nil
+}